@@ -0,0 +1,203 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// logFormat is the --format value: "auto", "json", "logfmt", or "text".
+var logFormat = "auto"
+
+// Flag-collected, unparsed forms. Mirrors ignoreRe/ignoreLine/etc in prolix.go.
+var (
+	ignoreFieldFlag   = make([]string, 0) // "key=value"
+	ignoreFieldReFlag = make([]string, 0) // "key:regex"
+	selectFieldFlag   = make([]string, 0) // "key=value"
+	redactFieldFlag   = make([]string, 0) // "key1,key2"
+)
+
+// Parsed/compiled forms, built by importFieldFilters.
+var (
+	ignoreFieldVals   []fieldMatch
+	ignoreFieldReVals []fieldReMatch
+	selectFieldVals   []fieldMatch
+	redactFields      = make([]string, 0)
+	redactFieldVals   []redactMatch
+)
+
+// detectedFormat is the format we've settled on for this run. It's set
+// once, lazily, the first time we need to parse fields out of a line --
+// that's the "auto" part of --format=auto.
+var detectedFormat string
+
+type fieldMatch struct{ key, value string }
+
+type fieldReMatch struct {
+	key string
+	re  *regexp.Regexp
+}
+
+// redactMatch holds the two precompiled regexps --redact-field needs to
+// blank out one key's value, in logfmt and JSON line shapes respectively.
+type redactMatch struct {
+	key    string
+	logfmt *regexp.Regexp
+	jsonRe *regexp.Regexp
+}
+
+// compileRedactFields precompiles the logfmt/JSON regexps for each
+// --redact-field key, so redactLine doesn't pay regexp.Compile's cost on
+// every line.
+func compileRedactFields(keys []string) []redactMatch {
+	vals := make([]redactMatch, len(keys))
+	for i, key := range keys {
+		vals[i] = redactMatch{
+			key:    key,
+			logfmt: regexp.MustCompile(regexp.QuoteMeta(key) + `=("(?:[^"\\]|\\.)*"|\S*)`),
+			jsonRe: regexp.MustCompile(`"` + regexp.QuoteMeta(key) + `"\s*:\s*("(?:[^"\\]|\\.)*"|[^,}]*)`),
+		}
+	}
+	return vals
+}
+
+// logfmtPair matches one key=value (or key="quoted value") token.
+var logfmtPair = regexp.MustCompile(`([\w.\-]+)=("(?:[^"\\]|\\.)*"|\S*)`)
+
+// importFieldFilters compiles the --ignore-field[-re]/--select-field/
+// --redact-field flags. Called once at startup, after option parsing.
+func importFieldFilters() error {
+	for _, kv := range ignoreFieldFlag {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid --ignore-field %q, want key=value", kv)
+		}
+		ignoreFieldVals = append(ignoreFieldVals, fieldMatch{key, value})
+	}
+	for _, kv := range ignoreFieldReFlag {
+		key, pat, ok := strings.Cut(kv, ":")
+		if !ok {
+			return fmt.Errorf("invalid --ignore-field-re %q, want key:regex", kv)
+		}
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return err
+		}
+		ignoreFieldReVals = append(ignoreFieldReVals, fieldReMatch{key, re})
+	}
+	for _, kv := range selectFieldFlag {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid --select-field %q, want key=value", kv)
+		}
+		selectFieldVals = append(selectFieldVals, fieldMatch{key, value})
+	}
+	for _, csv := range redactFieldFlag {
+		redactFields = append(redactFields, strings.Split(csv, ",")...)
+	}
+	redactFieldVals = compileRedactFields(redactFields)
+	return nil
+}
+
+// parseFields extracts key/value pairs from line according to
+// detectedFormat (resolving "auto" on the first call). ok is false when
+// the format is "text", or the line didn't parse as the detected format.
+func parseFields(line string) (fields map[string]string, ok bool) {
+	format := logFormat
+	if format == "auto" {
+		if detectedFormat == "" {
+			detectedFormat = guessFormat(line)
+		}
+		format = detectedFormat
+	}
+	switch format {
+	case "json":
+		return parseJSONFields(line)
+	case "logfmt":
+		return parseLogfmtFields(line)
+	default:
+		return nil, false
+	}
+}
+
+// guessFormat sniffs a single line to decide between json, logfmt, and
+// text. It's deliberately cheap: a real auto-detector would look at
+// several lines, but prolix sees its input one line at a time.
+func guessFormat(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "{") {
+		if _, ok := parseJSONFields(trimmed); ok {
+			return "json"
+		}
+	}
+	if logfmtPair.MatchString(trimmed) {
+		return "logfmt"
+	}
+	return "text"
+}
+
+func parseJSONFields(line string) (map[string]string, bool) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, false
+	}
+	fields := make(map[string]string, len(raw))
+	for k, v := range raw {
+		fields[k] = fmt.Sprintf("%v", v)
+	}
+	return fields, true
+}
+
+func parseLogfmtFields(line string) (map[string]string, bool) {
+	matches := logfmtPair.FindAllStringSubmatch(line, -1)
+	if len(matches) == 0 {
+		return nil, false
+	}
+	fields := make(map[string]string, len(matches))
+	for _, m := range matches {
+		fields[m[1]] = strings.Trim(m[2], `"`)
+	}
+	return fields, true
+}
+
+// okFields reports whether a line whose fields parsed out to fields
+// should be kept, applying --ignore-field[-re] and --select-field.
+// Assumes stateMu is already held by the caller (okLine).
+func okFields(fields map[string]string) bool {
+	for _, f := range ignoreFieldVals {
+		if fields[f.key] == f.value {
+			return false
+		}
+	}
+	for _, f := range ignoreFieldReVals {
+		if f.re.MatchString(fields[f.key]) {
+			return false
+		}
+	}
+	for _, f := range selectFieldVals {
+		if fields[f.key] != f.value {
+			return false
+		}
+	}
+	return true
+}
+
+// redactLine replaces the value of each --redact-field key with "***" in
+// the raw line text, without otherwise reformatting it. The regexps are
+// precompiled by importFieldFilters, since this runs on every line.
+func redactLine(line string) string {
+	if len(redactFieldVals) == 0 {
+		return line
+	}
+	out := line
+	for _, f := range redactFieldVals {
+		// logfmt-style key=value or key="value"
+		out = f.logfmt.ReplaceAllString(out, f.key+`=***`)
+		// JSON-style "key":"value" or "key":value
+		out = f.jsonRe.ReplaceAllString(out, `"`+f.key+`":"***"`)
+	}
+	return out
+}
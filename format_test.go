@@ -0,0 +1,87 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGuessFormat(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`{"msg": "hello", "level": "info"}`, "json"},
+		{`time=2024-01-01T00:00:00Z level=info msg="hello there"`, "logfmt"},
+		{`just some plain text`, "text"},
+		{`{not valid json`, "text"},
+	}
+	for _, tt := range tests {
+		if got := guessFormat(tt.line); got != tt.want {
+			t.Errorf("guessFormat(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseLogfmtFields(t *testing.T) {
+	tests := []struct {
+		line string
+		want map[string]string
+		ok   bool
+	}{
+		{
+			`level=info msg="hello there" user=alice`,
+			map[string]string{"level": "info", "msg": "hello there", "user": "alice"},
+			true,
+		},
+		{
+			`just some plain text`,
+			nil,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		got, ok := parseLogfmtFields(tt.line)
+		if ok != tt.ok {
+			t.Errorf("parseLogfmtFields(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLogfmtFields(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestRedactLine(t *testing.T) {
+	old := redactFieldVals
+	defer func() { redactFieldVals = old }()
+
+	tests := []struct {
+		fields []string
+		line   string
+		want   string
+	}{
+		{
+			[]string{"password"},
+			`user=alice password=hunter2`,
+			`user=alice password=***`,
+		},
+		{
+			[]string{"token"},
+			`{"user":"alice","token":"abc123"}`,
+			`{"user":"alice","token":"***"}`,
+		},
+		{
+			nil,
+			`user=alice password=hunter2`,
+			`user=alice password=hunter2`,
+		},
+	}
+	for _, tt := range tests {
+		redactFieldVals = compileRedactFields(tt.fields)
+		if got := redactLine(tt.line); got != tt.want {
+			t.Errorf("redactLine(%q) with redactFields=%v = %q, want %q", tt.line, tt.fields, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+// Package prompt wraps chzyer/readline so prolix's interactive mode gets
+// persistent history, Ctrl-R reverse search, and real tab completion,
+// instead of hand-rolling them on top of bobappleyard/readline.
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"golang.org/x/term"
+)
+
+// Editor is a single interactive prompt session, backed by a history file
+// that survives across prolix invocations.
+type Editor struct {
+	rl *readline.Instance
+}
+
+// New creates an Editor that prints prompt and loads/saves history at
+// historyPath, creating its parent directory if necessary. completions is
+// called with everything the user has typed so far (up to the cursor)
+// each time they hit Tab, and should return every full candidate that
+// could complete it -- New takes care of matching prefixes and cursor math.
+func New(prompt, historyPath string, completions func(text string) []string) (*Editor, error) {
+	if dir := filepath.Dir(historyPath); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:       prompt,
+		HistoryFile:  historyPath,
+		AutoComplete: completerFunc(completions),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Editor{rl: rl}, nil
+}
+
+// ReadLine blocks for one line of input. readline.ErrInterrupt is
+// returned on Ctrl-C; io.EOF on Ctrl-D.
+func (e *Editor) ReadLine() (string, error) {
+	return e.rl.Readline()
+}
+
+// AddHistory appends line to the in-memory and on-disk history, so a
+// later Ctrl-R can find it even in a future prolix run.
+func (e *Editor) AddHistory(line string) error {
+	return e.rl.SaveHistory(line)
+}
+
+// Close flushes history and restores the terminal.
+func (e *Editor) Close() error {
+	return e.rl.Close()
+}
+
+// WaitKeypress blocks until a single key is struck on the raw terminal,
+// without starting a full ReadLine session. prolix uses this to notice
+// "the user wants to go interactive" while the spawned child still owns
+// the tty's cooked-mode behavior the rest of the time.
+func WaitKeypress() error {
+	fd := int(os.Stdin.Fd())
+	old, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, old)
+	var buf [1]byte
+	_, err = os.Stdin.Read(buf[:])
+	return err
+}
+
+// completerFunc adapts a plain func(text string) []string to
+// readline.AutoCompleter.
+type completerFunc func(text string) []string
+
+func (f completerFunc) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	text := string(line[:pos])
+	word := text
+	if i := strings.LastIndexAny(text, " \t"); i >= 0 {
+		word = text[i+1:]
+	}
+	for _, candidate := range f(text) {
+		if strings.HasPrefix(candidate, word) {
+			newLine = append(newLine, []rune(candidate[len(word):]))
+		}
+	}
+	return newLine, len(word)
+}
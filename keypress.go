@@ -0,0 +1,44 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+package main
+
+import "github.com/gaal/prolix-go/internal/prompt"
+
+// keypressWatcher runs a single long-lived goroutine that waits for one
+// raw keystroke at a time on stdin. It's created once per prolixSpawn
+// run (not once per demux cycle) so that --watch/--restart-on-exit/the
+// interactive "restart" command don't pile up extra readers on the same
+// fd across restarts; each demux cycle just borrows the same watcher and
+// must call Release after it's done handling a keypress, to let the next
+// one through.
+type keypressWatcher struct {
+	notify chan struct{}
+	ack    chan struct{}
+}
+
+func newKeypressWatcher() *keypressWatcher {
+	w := &keypressWatcher{
+		notify: make(chan struct{}),
+		ack:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *keypressWatcher) run() {
+	for {
+		if err := prompt.WaitKeypress(); err != nil {
+			return
+		}
+		w.notify <- struct{}{}
+		<-w.ack
+	}
+}
+
+// Release lets the watcher go back to waiting for the next keystroke.
+// Every demux cycle that reads from notify must eventually call this
+// exactly once, whether the interactive session it started finished
+// normally or was cut short by a restart.
+func (w *keypressWatcher) Release() {
+	w.ack <- struct{}{}
+}
@@ -0,0 +1,166 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// listenAddr holds the --listen value, e.g. "8080" or "localhost:8080".
+// Empty means the control listener is disabled.
+var listenAddr string
+
+// controlSignal carries quit/kill/rotate/restart requests from the HTTP
+// listener, the --watch watcher, and the interactive "restart" command into
+// demux's select loop. It's buffered so a
+// POST /signal doesn't block on a demux that hasn't started listening yet.
+var controlSignal = make(chan string, 4)
+
+// normalizeListenAddr turns a bare port, as fzf accepts for its own
+// --listen, into a full "addr:port" suitable for http.ListenAndServe.
+// Control listener commands like /signal kill aren't authenticated, so a
+// bare port binds 127.0.0.1 rather than every interface; give an explicit
+// host such as "0.0.0.0", or an empty one as in ":8080", to open it up on
+// purpose.
+func normalizeListenAddr(addr string) string {
+	if addr == "" {
+		return "127.0.0.1:0"
+	}
+	if !strings.Contains(addr, ":") {
+		return "127.0.0.1:" + addr
+	}
+	return addr
+}
+
+// startListener runs the control HTTP server. It's meant to be run in its
+// own goroutine; errors are reported but don't kill the main program.
+func startListener(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ignore-re", handleAddPattern(addIgnoreRe))
+	mux.HandleFunc("/ignore-line", handleAddPatternNoErr(addIgnoreLine))
+	mux.HandleFunc("/ignore-substring", handleAddPatternNoErr(addIgnoreSubstring))
+	mux.HandleFunc("/snippet", handleSnippet)
+	mux.HandleFunc("/stats", handleStats)
+	mux.HandleFunc("/pats", handlePats)
+	mux.HandleFunc("/signal", handleSignal)
+
+	full := normalizeListenAddr(addr)
+	if verbose {
+		fmt.Printf("Control listener on %s\n", full)
+	}
+	if err := http.ListenAndServe(full, mux); err != nil {
+		fmt.Fprintln(os.Stderr, "prolix: control listener:", err)
+	}
+}
+
+// handleAddPattern wraps an add func that can fail (e.g. addIgnoreRe,
+// which compiles a regexp) into an http.HandlerFunc that reads the
+// pattern from the POST body.
+func handleAddPattern(add func(string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "want POST", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := readBody(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body == "" {
+			http.Error(w, "empty pattern", http.StatusBadRequest)
+			return
+		}
+		if err := add(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleAddPatternNoErr is handleAddPattern for the add funcs that can't fail.
+func handleAddPatternNoErr(add func(string)) http.HandlerFunc {
+	return handleAddPattern(func(s string) error {
+		add(s)
+		return nil
+	})
+}
+
+func handleSnippet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "want POST", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body == "" {
+		http.Error(w, "empty substitution", http.StatusBadRequest)
+		return
+	}
+	if !addSnippet(body) {
+		http.Error(w, "invalid substitution: "+body, http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	total, suppressed := currentStats()
+	writeJSON(w, map[string]int{
+		"total":      total,
+		"suppressed": suppressed,
+	})
+}
+
+func handlePats(w http.ResponseWriter, r *http.Request) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	writeJSON(w, map[string][]string{
+		"ignore_re":        ignoreRe,
+		"ignore_line":      ignoreLine,
+		"ignore_substring": ignoreSubstring,
+		"snippet":          snippet,
+	})
+}
+
+// handleSignal accepts "quit", "kill", "rotate", or "restart" in the POST body and
+// forwards it to demux via controlSignal.
+func handleSignal(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch strings.TrimSpace(body) {
+	case "quit", "kill", "rotate", "restart":
+		controlSignal <- strings.TrimSpace(body)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unknown signal: "+body, http.StatusBadRequest)
+	}
+}
+
+func readBody(r *http.Request) (string, error) {
+	defer r.Body.Close()
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+package main
+
+import "testing"
+
+func TestNormalizeListenAddr(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "127.0.0.1:0"},
+		{"8080", "127.0.0.1:8080"},
+		{"localhost:8080", "localhost:8080"},
+		{"0.0.0.0:8080", "0.0.0.0:8080"},
+		{":8080", ":8080"},
+	}
+	for _, tt := range tests {
+		if got := normalizeListenAddr(tt.in); got != tt.want {
+			t.Errorf("normalizeListenAddr(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Flag-collected, unparsed forms.
+var (
+	logMaxSizeFlag string
+	logMaxFiles    = 5
+	logCompress    bool
+	logOutFormat   = "raw"
+)
+
+// logMaxSize is logMaxSizeFlag parsed into bytes; 0 means no size cap.
+var logMaxSize int64
+
+// parseLogFlags parses --log-max-size into logMaxSize and validates
+// --log-format (--log-max-files is parsed by myParse). Called once at
+// startup, after option parsing.
+func parseLogFlags() error {
+	if logMaxSizeFlag != "" {
+		size, err := parseSize(logMaxSizeFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --log-max-size %q: %w", logMaxSizeFlag, err)
+		}
+		logMaxSize = size
+	}
+	switch logOutFormat {
+	case "raw", "json":
+	default:
+		return fmt.Errorf("invalid --log-format %q, want raw or json", logOutFormat)
+	}
+	return nil
+}
+
+// parseSize parses strings like "10MB", "512KB", or a bare byte count.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(s), u.suffix) {
+			num := strings.TrimSuffix(strings.ToUpper(s), u.suffix)
+			n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * u.factor, nil
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// logWriter is a size-capped, rotating *os.File. When a write would push
+// it past maxSize, it closes the current file, shifts path.1, path.2, ...
+// up by one (dropping anything past maxFiles), optionally gzips the
+// file that just got shifted out, and opens path fresh.
+type logWriter struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	maxSize  int64
+	maxFiles int
+	compress bool
+
+	// compressWG tracks the in-flight background gzip of the file this
+	// writer's *previous* rotation shifted out, if any. rotateLocked
+	// waits on it before touching the numbered files again, so a second
+	// rotation can never shift or remove a file compressFile still has
+	// open.
+	compressWG sync.WaitGroup
+}
+
+func newLogWriter(path string, maxSize int64, maxFiles int, compress bool) (*logWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &logWriter{
+		path:     path,
+		file:     f,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		compress: compress,
+	}, nil
+}
+
+// WriteString writes s, rotating first if it would exceed maxSize.
+func (w *logWriter) WriteString(s string) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.maxSize > 0 && w.size+int64(len(s)) > w.maxSize {
+		if err := w.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.WriteString(s)
+	w.size += int64(n)
+	return n, err
+}
+
+// logRecord is the shape of one --log-format=json line.
+type logRecord struct {
+	Ts     string `json:"ts"`
+	Stream string `json:"stream"`
+	Msg    string `json:"msg"`
+}
+
+// WriteJSON writes line as a {ts, stream, msg} JSON object plus newline.
+func (w *logWriter) WriteJSON(stream, line string) error {
+	raw, err := json.Marshal(logRecord{
+		Ts:     time.Now().Format(time.RFC3339Nano),
+		Stream: stream,
+		Msg:    strings.TrimRight(line, "\n"),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteString(string(raw) + "\n")
+	return err
+}
+
+// Rotate forces an immediate rotation, for the interactive "rotate"
+// command and POST /signal {rotate}.
+func (w *logWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotateLocked()
+}
+
+func (w *logWriter) rotateLocked() error {
+	// Wait for any gzip left running by our own previous rotation before
+	// we shift or remove numbered files out from under it.
+	w.compressWG.Wait()
+
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if w.maxFiles > 0 {
+		oldest := fmt.Sprintf("%s.%d", w.path, w.maxFiles)
+		os.Remove(oldest)
+		os.Remove(oldest + ".gz")
+		for i := w.maxFiles - 1; i >= 1; i-- {
+			from := fmt.Sprintf("%s.%d", w.path, i)
+			to := fmt.Sprintf("%s.%d", w.path, i+1)
+			if _, err := os.Stat(from + ".gz"); err == nil {
+				os.Rename(from+".gz", to+".gz")
+			} else {
+				os.Rename(from, to)
+			}
+		}
+	}
+
+	rotated := w.path + ".1"
+	if err := os.Rename(w.path, rotated); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if w.compress {
+		w.compressWG.Add(1)
+		go func() {
+			defer w.compressWG.Done()
+			compressFile(rotated)
+		}()
+	}
+
+	f, err := os.Create(w.path)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.size = 0
+	return nil
+}
+
+// compressFile gzips path to path+".gz" in the background and removes
+// the uncompressed copy, so a long-running server doesn't stall on it.
+func compressFile(path string) {
+	in, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		gz.Close()
+		return
+	}
+	if err := gz.Close(); err != nil {
+		return
+	}
+	os.Remove(path)
+}
+
+func (w *logWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.compressWG.Wait()
+	return w.file.Close()
+}
@@ -0,0 +1,65 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"10MB", 10 << 20, false},
+		{"512KB", 512 << 10, false},
+		{"1GB", 1 << 30, false},
+		{"100B", 100, false},
+		{"100", 100, false},
+		{"nonsense", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLogWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+
+	w, err := newLogWriter(path, 10, 2, false)
+	if err != nil {
+		t.Fatalf("newLogWriter: %v", err)
+	}
+	defer w.Close()
+
+	// Each write exactly fills maxSize, so the second and third writes
+	// each rotate first; the explicit Rotate below makes a third rotation.
+	for i := 0; i < 3; i++ {
+		if _, err := w.WriteString("0123456789"); err != nil {
+			t.Fatalf("WriteString: %v", err)
+		}
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	for _, suffix := range []string{".1", ".2"} {
+		if _, err := os.Stat(path + suffix); err != nil {
+			t.Errorf("expected rotated file %s to exist: %v", path+suffix, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no %s.3, maxFiles=2 should have dropped it", path)
+	}
+}
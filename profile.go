@@ -0,0 +1,189 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// profileName overrides the auto-detected profile key when set via
+// --profile=NAME. Empty means derive the key from args[0].
+var profileName string
+
+var (
+	listProfilesFlag bool
+	editProfileFlag  bool
+)
+
+// saveOnQuit tracks whether interactively-added filters should be persisted
+// to the current profile when the user quits. True by default; the
+// "forget" interactive command flips it off for the rest of the session.
+var saveOnQuit = true
+
+// currentProfile is the key of the profile in effect for this run, or ""
+// if profiles are disabled (e.g., pipe mode with no --profile).
+var currentProfile string
+
+// profileData is the on-disk shape of a profile. It mirrors the global
+// filter slices in prolix.go so (de)serialization is a straight copy.
+type profileData struct {
+	IgnoreRe        []string `json:"ignore_re,omitempty"`
+	IgnoreLine      []string `json:"ignore_line,omitempty"`
+	IgnoreSubstring []string `json:"ignore_substring,omitempty"`
+	Snippet         []string `json:"snippet,omitempty"`
+}
+
+// profileDir returns ~/.config/prolix, creating it if necessary.
+func profileDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".config", "prolix")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// profilePath returns the path a profile named key would live at.
+// TODO(gaal): support a legacy .toml extension once we pick a TOML lib;
+// for now profiles are plain JSON.
+func profilePath(key string) (string, error) {
+	dir, err := profileDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, key+".json"), nil
+}
+
+// profileKey derives the profile key for a spawned command: the basename
+// of args[0], unless --profile overrides it.
+func profileKey(args []string) string {
+	if profileName != "" {
+		return profileName
+	}
+	if len(args) == 0 {
+		return ""
+	}
+	return filepath.Base(args[0])
+}
+
+// loadProfile reads the profile for key, if any. A missing profile is not
+// an error; it just returns a zero profileData.
+func loadProfile(key string) (profileData, error) {
+	var data profileData
+	if key == "" {
+		return data, nil
+	}
+	path, err := profilePath(key)
+	if err != nil {
+		return data, err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return data, nil
+		}
+		return data, err
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+// saveProfile writes the current in-memory filters to key's profile file.
+func saveProfile(key string) error {
+	if key == "" {
+		return nil
+	}
+	path, err := profilePath(key)
+	if err != nil {
+		return err
+	}
+	stateMu.Lock()
+	data := profileData{
+		IgnoreRe:        append([]string{}, ignoreRe...),
+		IgnoreLine:      append([]string{}, ignoreLine...),
+		IgnoreSubstring: append([]string{}, ignoreSubstring...),
+		Snippet:         append([]string{}, snippet...),
+	}
+	stateMu.Unlock()
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0600)
+}
+
+// mergeProfile loads key's profile (if any) and prepends its filters to
+// the CLI-supplied ones, so CLI flags never silently replace the saved
+// profile -- they just add to it.
+func mergeProfile(key string) error {
+	currentProfile = key
+	data, err := loadProfile(key)
+	if err != nil {
+		return err
+	}
+	ignoreRe = append(append([]string{}, data.IgnoreRe...), ignoreRe...)
+	ignoreLine = append(append([]string{}, data.IgnoreLine...), ignoreLine...)
+	ignoreSubstring = append(append([]string{}, data.IgnoreSubstring...), ignoreSubstring...)
+	snippet = append(append([]string{}, data.Snippet...), snippet...)
+	return nil
+}
+
+// listProfiles prints the name of every saved profile to stdout.
+func listProfiles() {
+	dir, err := profileDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "prolix: can't list profiles:", err)
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "prolix: can't list profiles:", err)
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, strings.TrimSuffix(e.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Println(n)
+	}
+}
+
+// editProfile opens key's profile file in $EDITOR. If it doesn't exist yet,
+// it's created first from the filters given on the command line (empty if
+// there are none).
+func editProfile(key string) error {
+	if key == "" {
+		return fmt.Errorf("no profile to edit (pass --profile=NAME)")
+	}
+	path, err := profilePath(key)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := saveProfile(key); err != nil {
+			return err
+		}
+	}
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command(editor, path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -22,7 +22,9 @@
 
    Since Prolix knows your command line, it can figure out a profile for
    commands you run, so it'll remember different filters for different
-   commands. [notyet]
+   commands. The profile key is the basename of the spawned command, or
+   whatever you pass to --profile. Filters you add interactively are
+   appended to the profile when you quit, unless you say "forget" first.
 
    You can run existing output via a pipe to prolix and thus filter it, but
    the usual way of invoking it is to pass the command to run on its own
@@ -41,15 +43,16 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/bobappleyard/readline"
 	"github.com/gaal/go-options/options"
+	"github.com/gaal/prolix-go/internal/prompt"
 )
 
 const versionString = "0.03-go"
@@ -69,6 +72,24 @@ r,ignore-re= ignore lines matching this regexp.
 n,ignore-line= ignore lines equal to this entirely.
 b,ignore-substring= ignore lines containing this substring.
 s,snippet= trim the line with this substitution. e.g., s/DEBUG|INFO//.
+profile= use this profile name instead of the spawned command's basename.
+list-profiles list known profiles and exit.
+edit-profile edit the current profile in $EDITOR and exit.
+listen= start a control listener, e.g. --listen=8080 or --listen=localhost:8080.
+format= log format: auto, json, logfmt, or text. Default auto.
+ignore-field= ignore lines where a structured field matches, e.g. level=DEBUG.
+ignore-field-re= ignore lines where a structured field matches a regexp, e.g. msg:^health-check.
+select-field= only keep lines where a structured field matches, e.g. service=api.
+redact-field= comma-separated field names whose values get replaced with ***.
+with-shell= run the spawned command through this shell instead of $SHELL -c, e.g. 'bash -lc'.
+watch= comma-separated paths to watch; restart the spawned command when any of them change.
+restart-on-exit restart the spawned command whenever it exits nonzero.
+no-clear don't clear the screen on restart.
+watch-debounce= time to wait after a watched change before restarting. Default 500ms.
+log-max-size= rotate the log once it reaches this size, e.g. 10MB. Default unlimited.
+log-max-files= keep at most this many rotated logs around. Default 5.
+log-compress gzip rotated logs in the background.
+log-format= log line format: raw or json. Default raw.
 `
 
 var (
@@ -82,6 +103,11 @@ var (
 	snippet         = make([]string, 0)
 )
 
+// stateMu guards every global that interact() and, now, the --listen HTTP
+// server can both mutate: the filter slices above, and their compiled
+// forms and the running line counts below.
+var stateMu sync.Mutex
+
 var (
 	ignoreReVals     = make([]*regexp.Regexp, 0)
 	substitutionVals = make([]Substitution, 0)
@@ -94,7 +120,7 @@ var (
 	// The command being run if we're in spawn mode, or nil.
 	spawnedProgram *string
 
-	logFile *os.File
+	activeLog *logWriter
 )
 
 type Substitution struct {
@@ -111,6 +137,16 @@ func myParse(s *options.OptionSpec, option string, value *string) {
 			pipe = true
 		case "verbose":
 			verbose = true
+		case "list-profiles":
+			listProfilesFlag = true
+		case "edit-profile":
+			editProfileFlag = true
+		case "restart-on-exit":
+			restartOnExit = true
+		case "no-clear":
+			noClear = true
+		case "log-compress":
+			logCompress = true
 		case "version":
 			{
 				fmt.Printf("prolix %s\n", versionString)
@@ -131,6 +167,36 @@ func myParse(s *options.OptionSpec, option string, value *string) {
 			ignoreSubstring = append(ignoreSubstring, *value)
 		case "snippet":
 			snippet = append(snippet, *value)
+		case "profile":
+			profileName = *value
+		case "listen":
+			listenAddr = *value
+		case "format":
+			logFormat = *value
+		case "ignore-field":
+			ignoreFieldFlag = append(ignoreFieldFlag, *value)
+		case "ignore-field-re":
+			ignoreFieldReFlag = append(ignoreFieldReFlag, *value)
+		case "select-field":
+			selectFieldFlag = append(selectFieldFlag, *value)
+		case "redact-field":
+			redactFieldFlag = append(redactFieldFlag, *value)
+		case "with-shell":
+			withShell = *value
+		case "watch":
+			watchPathsFlag = *value
+		case "watch-debounce":
+			watchDebounceStr = *value
+		case "log-max-size":
+			logMaxSizeFlag = *value
+		case "log-max-files":
+			if n, err := strconv.Atoi(*value); err == nil {
+				logMaxFiles = n
+			} else {
+				s.PrintUsageAndExit("invalid --log-max-files: " + *value)
+			}
+		case "log-format":
+			logOutFormat = *value
 		default:
 			s.PrintUsageAndExit("Unknown option: " + option)
 		}
@@ -143,6 +209,54 @@ func importIgnoreRE(pats []string) {
 	}
 }
 
+// addIgnoreRe compiles and registers a new ignore-re pattern. It's the
+// shared entry point for both the "ignore-re" interactive command and the
+// POST /ignore-re HTTP endpoint.
+func addIgnoreRe(pat string) error {
+	re, err := regexp.Compile(pat)
+	if err != nil {
+		return err
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	ignoreRe = append(ignoreRe, pat)
+	ignoreReVals = append(ignoreReVals, re)
+	return nil
+}
+
+// addIgnoreLine registers a new full-line ignore match.
+func addIgnoreLine(s string) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	ignoreLine = append(ignoreLine, s)
+}
+
+// addIgnoreSubstring registers a new substring ignore match.
+func addIgnoreSubstring(s string) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	ignoreSubstring = append(ignoreSubstring, s)
+}
+
+// addSnippet parses and registers a new snippet substitution. It reports
+// whether sub was a valid substitution expression.
+func addSnippet(sub string) bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if !importSnippet([]string{sub}) {
+		return false
+	}
+	snippet = append(snippet, sub)
+	return true
+}
+
+// currentStats returns the running line counts under the lock.
+func currentStats() (total, suppressed int) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return linesTotal, linesSuppressed
+}
+
 func importSnippet(subsitutions []string) (ok bool) {
 	for _, sub := range subsitutions {
 		if len(sub) < 4 {
@@ -232,26 +346,48 @@ func openLog() {
 	}
 	filename = strings.Replace(filename, "%d", nowString, -1)
 
-	/*
-		    Bizarre! This panics with "nil". How can it?
-			if file, err := os.Create(filename); err != nil {
-				// TODO(gaal): bufio.NewWriter, but that's not WriterCloser?
-				logFile = file
-			} else {
-				panic(err)
-			}
-	*/
-	logFile, _ = os.Create(filename) // TODO(gaal): Handle errors.
+	w, err := newLogWriter(filename, logMaxSize, logMaxFiles, logCompress)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "prolix: can't open log:", err) // TODO(gaal): Handle errors.
+		return
+	}
+	activeLog = w
 }
 
 func closeLog() {
-	if logFile != nil {
-		if err := logFile.Close(); err != nil {
+	if activeLog != nil {
+		if err := activeLog.Close(); err != nil {
 			panic(err)
 		}
+		activeLog = nil
+	}
+}
+
+// rotateLog forces the active log to rotate now, for the interactive
+// "rotate" command and POST /signal {rotate}. It's a no-op if logging
+// isn't enabled.
+func rotateLog() {
+	if activeLog == nil {
+		return
+	}
+	if err := activeLog.Rotate(); err != nil {
+		fmt.Fprintln(os.Stderr, "prolix: can't rotate log:", err)
 	}
 }
 
+// writeLog appends line to the active log file, if any, in whichever of
+// --log-format={raw,json} the user asked for.
+func writeLog(stream, line string) error {
+	if activeLog == nil {
+		return nil
+	}
+	if logOutFormat == "json" {
+		return activeLog.WriteJSON(stream, line)
+	}
+	_, err := activeLog.WriteString(line)
+	return err
+}
+
 var completionWords = []string{
 	"ignore-line",
 	"ignore-re",
@@ -260,32 +396,122 @@ var completionWords = []string{
 
 	"pats",
 	"quit",
+	"save",
+	"forget",
+	"restart",
+	"rotate",
 	"stats",
 	"help"}
 
-func interactiveCompletion(text, ctx string) (out []string) {
-	for _, word := range completionWords {
-		if strings.HasPrefix(word, text) {
-			out = append(out, word)
-		}
+// historyPath is where interactive filter commands are remembered across
+// runs, so Ctrl-R can find "ignore-re ^DEBUG" from last week.
+const historyPath = ".local/state/prolix/history"
+
+// promptEditor is the interactive prompt for this run. It's nil in pipe
+// mode, where there's no tty to read commands from.
+var promptEditor *prompt.Editor
+
+// resetPromptEditor (re)opens promptEditor, closing whatever was there
+// before. demux calls this on a forced restart that cut an interactive
+// session short: closing the old instance makes its stuck ReadLine call
+// return an error instead of leaking forever, and opening a fresh one
+// lets the next interactive session use the prompt normally.
+func resetPromptEditor() {
+	if promptEditor != nil {
+		promptEditor.Close()
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "prolix: can't find history file:", err)
+		home = "."
+	}
+	editor, err := prompt.New("prolix> ", filepath.Join(home, historyPath), dynamicCompletions)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "prolix: can't start interactive prompt:", err)
+		promptEditor = nil
+		return
+	}
+	promptEditor = editor
+}
+
+// dynamicCompletions is promptEditor's completer: the first word always
+// completes against completionWords, but once that word is one of the
+// unary filter commands, the rest of the line completes against whatever
+// patterns of that kind are already in effect -- so "ignore-re <TAB>"
+// recalls regexes you typed earlier in this session.
+func dynamicCompletions(text string) []string {
+	fields := strings.SplitN(text, " ", 2)
+	if len(fields) < 2 {
+		return completionWords
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	switch strings.Replace(fields[0], "_", "-", -1) {
+	case "ignore-re":
+		return ignoreRe
+	case "ignore-line":
+		return ignoreLine
+	case "ignore-substring":
+		return ignoreSubstring
+	case "snippet":
+		return snippet
+	default:
+		return nil
 	}
-	return
 }
 
 func main() {
-	readline.Completer = interactiveCompletion
 	spec := options.NewOptions(optionSpec).SetParseCallback(myParse)
 	opt := spec.Parse(os.Args[1:])
 	args := opt.Leftover
+
+	if listProfilesFlag {
+		listProfiles()
+		os.Exit(0)
+	}
+	if editProfileFlag {
+		key := profileKey(args)
+		if err := editProfile(key); err != nil {
+			fmt.Fprintln(os.Stderr, "prolix:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if err := mergeProfile(profileKey(args)); err != nil {
+		fmt.Fprintln(os.Stderr, "prolix: can't load profile:", err)
+	}
 	importIgnoreRE(ignoreRe)
 	if !importSnippet(snippet) {
 		os.Exit(1)
 	}
+	if err := importFieldFilters(); err != nil {
+		fmt.Fprintln(os.Stderr, "prolix:", err)
+		os.Exit(1)
+	}
+	if err := parseLogFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, "prolix:", err)
+		os.Exit(1)
+	}
 	openLog()
 
+	if listenAddr != "" {
+		go startListener(listenAddr)
+	}
+	if err := startWatcher(); err != nil {
+		fmt.Fprintln(os.Stderr, "prolix:", err)
+		os.Exit(1)
+	}
+
 	if len(args) == 0 || pipe {
 		prolixPipe()
 	} else {
+		resetPromptEditor()
+		defer func() {
+			if promptEditor != nil {
+				promptEditor.Close()
+			}
+		}()
 		prolixSpawn(args)
 	}
 
@@ -295,38 +521,65 @@ func main() {
 	}
 }
 
+// prolixSpawn runs args, filtering its output, and keeps doing so across
+// restarts triggered by --watch, --restart-on-exit, or the interactive
+// "restart" command, until the user quits.
 func prolixSpawn(args []string) {
-	if verbose {
-		fmt.Printf("Running: %q\n", args)
+	var kp *keypressWatcher
+	if promptEditor != nil {
+		kp = newKeypressWatcher()
 	}
 
-	cmd := exec.Command(args[0], args[1:]...)
-	outReader, err := cmd.StdoutPipe()
-	if err != nil {
-		panic(err)
-	}
-	outc := make(chan string)
-	errReader, err := cmd.StderrPipe()
-	if err != nil {
-		panic(err)
-	}
-	errc := make(chan string)
-	err = cmd.Start()
-	if err != nil {
-		panic(err)
-	}
+	for restart := false; ; restart = true {
+		if restart {
+			if !noClear {
+				fmt.Print("\x1b[H\x1b[2J")
+			}
+			printRestartDivider()
+		}
+		if verbose {
+			fmt.Printf("Running: %q\n", args)
+		}
 
-	go readPipe(bufio.NewReader(outReader), outc)
-	go readPipe(bufio.NewReader(errReader), errc)
+		cmd := shellCommand(args)
+		outReader, err := cmd.StdoutPipe()
+		if err != nil {
+			panic(err)
+		}
+		outc := make(chan string)
+		errReader, err := cmd.StderrPipe()
+		if err != nil {
+			panic(err)
+		}
+		errc := make(chan string)
+		err = cmd.Start()
+		if err != nil {
+			panic(err)
+		}
+
+		go readPipe(bufio.NewReader(outReader), outc)
+		go readPipe(bufio.NewReader(errReader), errc)
 
-	doneDemux := make(chan string)
-	go demux(outc, errc, doneDemux)
-	res := <-doneDemux
-	if res == "kill" {
-		shutdown(cmd.Process)
+		doneDemux := make(chan string)
+		go demux(outc, errc, doneDemux, kp)
+		res := <-doneDemux
+		if res == "kill" || res == "restart" {
+			shutdown(cmd.Process)
+		}
+		err = cmd.Wait()
+		// TODO(gaal): exit with child err?
+
+		if res == "restart" {
+			continue
+		}
+		if res == "kill" {
+			return
+		}
+		if restartOnExit && err != nil {
+			continue
+		}
+		return
 	}
-	err = cmd.Wait()
-	// TODO(gaal): exit with child err?
 }
 
 func prolixPipe() {
@@ -346,7 +599,7 @@ func prolixPipe() {
 			// Ugh, I forgot how to initialize a slice?
 			wrapped := make([]string, 1)
 			wrapped[0] = line
-			filterLines(&wrapped)
+			filterLines(&wrapped, "stdout")
 		}
 
 		if err != nil {
@@ -386,30 +639,41 @@ func readPipe(pipe *bufio.Reader, ch chan<- string) {
 	}
 }
 
-func filterLines(lines *[]string) {
+// filterLines drains lines, printing and logging the ones that pass the
+// filters. stream ("stdout" or "stderr") is only used for --log-format=json.
+func filterLines(lines *[]string, stream string) {
 	for len(*lines) > 0 {
 		line := (*lines)[0]
+		stateMu.Lock()
 		linesTotal++
+		stateMu.Unlock()
 		trimmed := strings.TrimRight(line, "\n")
 		if okLine(trimmed) {
+			trimmed = redactLine(trimmed)
 			trimmed = substituteAll(trimmed)
 			if strings.HasSuffix(line, "\n") {
 				trimmed = trimmed + "\n"
 			}
 			fmt.Print(trimmed)
-			if logFile != nil {
-				if _, err := logFile.WriteString(trimmed); err != nil {
-					panic(err)
-				}
+			if err := writeLog(stream, trimmed); err != nil {
+				panic(err)
 			}
 		} else {
+			stateMu.Lock()
 			linesSuppressed++
+			stateMu.Unlock()
 		}
 		*lines = (*lines)[1:]
 	}
 }
 
+// okLine runs a chain of predicates over line, cheapest first, and keeps
+// the line only if every predicate passes. Equality and substring checks
+// go before anything that has to parse or run a regexp.
 func okLine(line string) bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
 	for _, v := range ignoreLine {
 		if line == v {
 			return false
@@ -420,6 +684,13 @@ func okLine(line string) bool {
 			return false
 		}
 	}
+	if len(ignoreFieldVals) > 0 || len(ignoreFieldReVals) > 0 || len(selectFieldVals) > 0 {
+		if fields, ok := parseFields(line); ok {
+			if !okFields(fields) {
+				return false
+			}
+		}
+	}
 	for _, v := range ignoreReVals {
 		if v.FindStringIndex(line) != nil {
 			return false
@@ -430,21 +701,37 @@ func okLine(line string) bool {
 
 // Gets additional suppression patterns, etc. from the user.
 func interact(done chan<- string) {
-	const prompt = "prolix> "
 L:
 	for {
-		cmd := readline.String(prompt)
-		if cmd == "" {
+		cmd, err := promptEditor.ReadLine()
+		if err != nil || cmd == "" {
 			break L
 		}
-		readline.AddHistory(cmd)
+		promptEditor.AddHistory(cmd)
 		unary := unaryRe.FindStringSubmatch(cmd)
 		if unary == nil {
 			trimmed := strings.TrimSpace(cmd)
 			switch trimmed {
 			case "quit":
+				if saveOnQuit {
+					if err := saveProfile(currentProfile); err != nil {
+						fmt.Fprintln(os.Stderr, "prolix: can't save profile:", err)
+					}
+				}
 				done <- "quit"
 				return
+			case "save":
+				if err := saveProfile(currentProfile); err != nil {
+					fmt.Fprintln(os.Stderr, "prolix: can't save profile:", err)
+				}
+			case "forget":
+				saveOnQuit = false
+				fmt.Println("Won't save filters to the profile on quit.")
+			case "restart":
+				controlSignal <- "restart"
+				return
+			case "rotate":
+				rotateLog()
 			case "pats":
 				printPats()
 			case "help":
@@ -455,15 +742,16 @@ L:
 		} else {
 			switch strings.Replace(unary[1], "_", "-", -1) {
 			case "ignore-re":
-				ignoreRe = append(ignoreRe, unary[2])
-				importIgnoreRE(unary[2:3])
+				if err := addIgnoreRe(unary[2]); err != nil {
+					fmt.Println("invalid regexp:", err)
+				}
 			case "ignore-line":
-				ignoreLine = append(ignoreLine, unary[2])
+				addIgnoreLine(unary[2])
 			case "ignore-substring":
-				ignoreSubstring = append(ignoreSubstring, unary[2])
+				addIgnoreSubstring(unary[2])
 			case "snippet":
-				if importSnippet(unary[2:3]) {
-					snippet = append(snippet, unary[2])
+				if !addSnippet(unary[2]) {
+					fmt.Println("invalid substitution:", unary[2])
 				}
 			default:
 				fmt.Println("Unknown unary command. Try 'help'.")
@@ -480,6 +768,10 @@ ignore-re        - add an ignore pattern, e.g. ^(FINE|DEBUG)
 ignore-substring - add a partial match to ignore
 pats             - list ignore patterns
 quit             - terminate running program
+save             - save current filters to the profile now
+forget           - don't save filters to the profile on quit
+restart          - restart the spawned command
+rotate           - force the log file to rotate now
 stats            - print stats
 snippet          - add a snippet expression, e.g. s/^(INFO|WARNING|ERROR) //
 
@@ -488,6 +780,8 @@ To keep going, just enter an empty line.
 }
 
 func printPats() {
+	stateMu.Lock()
+	defer stateMu.Unlock()
 	printList := func(name string, list []string) {
 		fmt.Printf(" * %s\n", name)
 		for _, v := range list {
@@ -500,27 +794,29 @@ func printPats() {
 	printList("snippet", snippet)
 }
 
-func listenKeypress(notify chan int) {
-	var buffer [1]byte
-	for {
-		// TODO(gaal): cook_SetRaw()
-		num, _ := os.Stdin.Read(buffer[:])
-		if num > 0 {
-			// TODO(gaal): cook_SetCooked(), and defer cook_SetCooked() in main.
-			notify <- 1
-			<-notify
-		}
-	}
-}
-
-func demux(outc, errc <-chan string, done chan<- string) {
+// demux reads outc/errc until both close, filtering and printing lines as
+// they arrive, and switches into interactive mode whenever kp reports a
+// keystroke. kp is shared across every restart of the same prolixSpawn
+// run (nil if the interactive prompt couldn't be started), so this
+// function must always call kp.Release() exactly once for every keypress
+// it consumes, even when cutting a session short to restart -- otherwise
+// kp's goroutine is left waiting forever for an ack that never comes. The
+// one exception is an interactive "quit", which ends the whole run and
+// returns without releasing kp.
+func demux(outc, errc <-chan string, done chan<- string, kp *keypressWatcher) {
 	var (
-		interacting     = false
-		outBuf, errBuf  = make([]string, 0), make([]string, 0)
-		keypress        = make(chan int)
-		doneInteractive = make(chan string)
+		interacting    = false
+		outBuf, errBuf = make([]string, 0), make([]string, 0)
+		// Buffered: if we cut an interactive session short below, the
+		// stray interact() goroutine's eventual done<-"" must not block
+		// forever on a channel nobody is reading from anymore.
+		doneInteractive = make(chan string, 1)
 	)
-	go listenKeypress(keypress)
+
+	var notify <-chan struct{}
+	if kp != nil {
+		notify = kp.notify
+	}
 
 	for interacting || outc != nil || errc != nil {
 		select {
@@ -528,7 +824,7 @@ func demux(outc, errc <-chan string, done chan<- string) {
 			if ok {
 				outBuf = append(outBuf, newOut)
 				if !interacting {
-					filterLines(&outBuf)
+					filterLines(&outBuf, "stdout")
 				}
 			} else {
 				outc = nil
@@ -537,27 +833,51 @@ func demux(outc, errc <-chan string, done chan<- string) {
 			if ok {
 				errBuf = append(errBuf, newErr)
 				if !interacting {
-					filterLines(&errBuf)
+					filterLines(&errBuf, "stderr")
 				}
 			} else {
 				errc = nil
 			}
-		case <-keypress:
+		case <-notify:
 			interacting = true
 			if verbose {
 				fmt.Println(
 					`Press ENTER to go back, or enter "help" for a list of commands.`)
 			}
 			go interact(doneInteractive)
+		case sig := <-controlSignal:
+			switch sig {
+			case "quit", "kill":
+				if interacting {
+					kp.Release()
+				}
+				done <- "kill"
+				return
+			case "rotate":
+				rotateLog()
+			case "restart":
+				if interacting {
+					// Unless the restart came from its own "restart"
+					// command, the running interact() goroutine is stuck in
+					// promptEditor.ReadLine(); closing and reopening the
+					// prompt makes that call return an error so it exits
+					// instead of leaking, and frees up kp for the next
+					// restart cycle to reuse.
+					resetPromptEditor()
+					kp.Release()
+				}
+				done <- "restart"
+				return
+			}
 		case res := <-doneInteractive:
 			if res == "quit" {
 				done <- "kill"
 				return
 			}
 			interacting = false
-			filterLines(&outBuf)
-			filterLines(&errBuf)
-			keypress <- 1
+			filterLines(&outBuf, "stdout")
+			filterLines(&errBuf, "stderr")
+			kp.Release()
 		}
 	}
 
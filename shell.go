@@ -0,0 +1,63 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// withShell is the --with-shell value, e.g. "bash -lc" or "ruby -e". Empty
+// means fall back to $SHELL -c, or sh -c / cmd /c if $SHELL isn't set.
+var withShell string
+
+// shellCommand builds the *exec.Cmd for a spawned command, running it
+// through a shell (as fzf does for its own child commands) instead of
+// exec'ing args[0] directly. That's what lets "prolix -- 'server | tee
+// raw.log'" pipe within the spawned command, source rc files, or hand the
+// line to another interpreter via --with-shell.
+func shellCommand(args []string) *exec.Cmd {
+	prefix := shellPrefix()
+	full := append(append([]string{}, prefix[1:]...), shellLine(args))
+	return exec.Command(prefix[0], full...)
+}
+
+// shellLine turns the spawned command's leftover args into the single
+// line sh -c expects. A single arg (the common "prolix -- 'cmd | other'"
+// case) is already a full command line and must be passed through
+// unquoted, or its pipes/redirects would reach the shell as literal text
+// instead of shell syntax. Only when there's more than one leftover arg
+// do we need to re-quote and join them into one line ourselves.
+func shellLine(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	return shellQuoteJoin(args)
+}
+
+// shellPrefix picks the shell invocation and its "run this line" flag.
+func shellPrefix() []string {
+	if fields := strings.Fields(withShell); len(fields) > 0 {
+		return fields
+	}
+	if sh := os.Getenv("SHELL"); sh != "" {
+		return []string{sh, "-c"}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/c"}
+	}
+	return []string{"sh", "-c"}
+}
+
+// shellQuoteJoin joins args into a single shell command line, single-
+// quoting each argument so the shell sees exactly the words prolix was
+// given on its own command line.
+func shellQuoteJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,37 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+package main
+
+import "testing"
+
+func TestShellLine(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"echo hi | tr a-z A-Z"}, "echo hi | tr a-z A-Z"},
+		{[]string{"mycmd", "--flag", "value"}, "'mycmd' '--flag' 'value'"},
+		{[]string{"it's"}, "it's"},
+		{[]string{"it's", "ok"}, `'it'\''s' 'ok'`},
+	}
+	for _, tt := range tests {
+		if got := shellLine(tt.args); got != tt.want {
+			t.Errorf("shellLine(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestShellPrefix(t *testing.T) {
+	old := withShell
+	defer func() { withShell = old }()
+
+	withShell = "bash -lc"
+	if got := shellPrefix(); len(got) != 2 || got[0] != "bash" || got[1] != "-lc" {
+		t.Errorf("shellPrefix() with --with-shell = %v, want [bash -lc]", got)
+	}
+
+	withShell = "   "
+	if got := shellPrefix(); len(got) == 0 {
+		t.Errorf("shellPrefix() with blank --with-shell = %v, want a non-empty fallback", got)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2011 Google Inc. All rights reserved.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var (
+	watchPathsFlag   string
+	restartOnExit    bool
+	noClear          bool
+	watchDebounceStr = "500ms"
+)
+
+// watchDebounce is watchDebounceStr parsed, filled in by startWatcher.
+var watchDebounce = 500 * time.Millisecond
+
+// startWatcher watches the comma-separated paths in watchPathsFlag and
+// pushes a "restart" signal at controlSignal -- the same channel the
+// --listen HTTP server and the interactive "restart" command use --
+// whenever one of them changes, debounced so a burst of writes (an editor
+// save, a `go build`) only triggers one restart.
+func startWatcher() error {
+	if watchPathsFlag == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(watchDebounceStr); err == nil {
+		watchDebounce = d
+	} else {
+		return fmt.Errorf("invalid --watch-debounce %q: %w", watchDebounceStr, err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, p := range strings.Split(watchPathsFlag, ",") {
+		if err := watcher.Add(p); err != nil {
+			return fmt.Errorf("--watch %q: %w", p, err)
+		}
+	}
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(watchDebounce, func() {
+					controlSignal <- "restart"
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Fprintln(os.Stderr, "prolix: watch error:", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// printRestartDivider marks a restart boundary both on the console and,
+// since writeLog writes through activeLog too, in the log.
+func printRestartDivider() {
+	divider := "----- prolix: restarting " + time.Now().Format(timestampFormat) + " -----\n"
+	fmt.Print(divider)
+	writeLog("restart", divider)
+}